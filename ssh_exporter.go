@@ -8,17 +8,24 @@ package main
 // Provides an HTTP endpoint to be consumed by Prometheus
 // which hosts pre-configured statistics found in config.yaml.
 //
-// Default endpoint: http://localhost:9382/probe?pattern=.*
+// Default endpoint: http://localhost:9428/probe?module=<name>&target=<host[:port]>
+//
+// Prometheus drives host discovery via relabel_configs (__param_target),
+// matching the multi-target pattern used by blackbox_exporter, instead of
+// hard-coding every host into config.yml.
 //
 
 import (
+	"github.com/Nordstrom/ssh_exporter/sshcollector"
 	"github.com/Nordstrom/ssh_exporter/util"
 
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/exporter-toolkit/web"
 )
 
 //
@@ -26,10 +33,15 @@ import (
 //
 var configPath string
 var servePort string
-var loggingEnabled bool
-var patternHelpText = `<p>Please include a valid <code>?pattern=[regex]</code>
-query parameter in your URL. This should match the <bold>name</bold> of the
-scripts you want to run (e.g., <code>?pattern=.*logs</code> matches
+var webConfigFile string
+var concurrency int
+var logLevel string
+var logFormat string
+var patternHelpText = `<p>Please include a valid <code>?target=[host[:port]]</code>
+query parameter in your URL along with either <code>?module=[name]</code> or
+<code>?pattern=[regex]</code>. <code>module</code> must match the
+<bold>name</bold> of a single module, while <code>pattern</code> matches the
+name of every module to run (e.g., <code>?pattern=.*logs</code> matches
 <code>chef_logs</code> and not <code>proc_status</code>)</p>.`
 
 //
@@ -37,14 +49,27 @@ scripts you want to run (e.g., <code>?pattern=.*logs</code> matches
 //
 func main() {
 
-	util.ParseFlags(&configPath, &servePort)
+	util.ParseFlags(&configPath, &servePort, &webConfigFile, &concurrency, &logLevel, &logFormat)
+	util.Logger = util.NewLogger(logLevel, logFormat)
 
 	http.HandleFunc("/", indexHandler)
 	http.HandleFunc("/probe", probeHandler)
 	http.Handle("/metrics", promhttp.Handler())
 
-	util.LogMsg(fmt.Sprintf("Listening on localhost:%s", servePort))
-	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%s", servePort), nil))
+	util.Logger.Info("listening", slog.String("port", servePort))
+
+	server := &http.Server{Addr: fmt.Sprintf(":%s", servePort)}
+
+	if webConfigFile == "" {
+		util.FatalCheck(server.ListenAndServe())
+	}
+
+	flags := &web.FlagConfig{
+		WebListenAddresses: &[]string{server.Addr},
+		WebConfigFile:      &webConfigFile,
+	}
+
+	util.FatalCheck(web.ListenAndServe(server, flags, util.Logger))
 }
 
 func indexHandler(w http.ResponseWriter, r *http.Request) {
@@ -62,14 +87,56 @@ func probeHandler(w http.ResponseWriter, r *http.Request) {
 	conf, err := util.ParseConfig(configPath)
 	util.SoftCheck(err)
 
-	pattern, err := util.ParseQuery(w, r)
+	pattern, target, err := util.ParseQuery(w, r)
 	if util.SoftCheck(err) {
 		fmt.Fprintf(w, patternHelpText)
-	} else {
-		util.BatchExecute(&conf, pattern)
+		return
+	}
+
+	logger := util.Logger.With(slog.String("target", target))
+	conf, _ = util.BatchExecute(&conf, pattern, target, concurrency, logger)
 
-		response, _ := util.PrometheusFormatResponse(conf)
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(sshcollector.New(probesFromConfig(conf)))
+
+	promhttp.HandlerFor(registry, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+}
 
-		fmt.Fprintf(w, response)
+//
+// probesFromConfig adapts the modules that ran during this scrape into the
+// sshcollector.Probe values its Collector reports.
+//
+func probesFromConfig(conf util.Config) []sshcollector.Probe {
+
+	var probes []sshcollector.Probe
+
+	for _, m := range conf.Modules {
+		if m.Ignored {
+			continue
+		}
+
+		var scriptMetrics []sshcollector.ScriptMetric
+		for _, sm := range m.ScriptMetrics {
+			scriptMetrics = append(scriptMetrics, sshcollector.ScriptMetric{
+				Name:   sm.Name,
+				Type:   sm.Type,
+				Value:  sm.Value,
+				Labels: sm.Labels,
+			})
+		}
+
+		probes = append(probes, sshcollector.Probe{
+			Module:             m.Name,
+			Host:               m.TargetHost,
+			Success:            m.ScriptError == "",
+			Duration:           m.Duration.Seconds(),
+			ExitCode:           m.ScriptReturnCode,
+			PatternMatched:     m.ResultPatternMatch == 1,
+			SSHConnectDuration: m.SSHConnectDuration.Seconds(),
+			ScriptMetrics:      scriptMetrics,
+			MetricParseErrors:  float64(m.MetricParseErrors),
+		})
 	}
+
+	return probes
 }
@@ -91,54 +91,6 @@ func TestUnitParseConfig(t *testing.T) {
 	compare(string(marshalled_conf), "test/parse_config.unit.txt", t)
 }
 
-//
-// Tests that the we're able to output Prometheus data correctly.
-//
-// Should produce a string similar to the HTTP endpoint result.
-//
-func TestUnitPrometheusFormatResponse(t *testing.T) {
-
-	fmt.Println("Running TestUnitPrometheusFormatResponse")
-
-	parsedTime, _ := time.ParseDuration("1s")
-
-	prom_conf := util.Config{
-		Version: "v0",
-		Scripts: []util.ScriptConfig{
-			util.ScriptConfig{
-				Name:    "scriptName",
-				Script:  "echo foo",
-				Timeout: "5s",
-				Pattern: "foo",
-				Credentials: []util.CredentialConfig{
-					util.CredentialConfig{
-						Host:               "localhost",
-						Port:               "2222",
-						User:               "username",
-						KeyFile:            "/noop",
-						ScriptResult:       "foo",
-						ScriptReturnCode:   0,
-						ScriptError:        "",
-						ResultPatternMatch: 1,
-					},
-				},
-				ParsedTimeout: parsedTime,
-				Ignored:       false,
-			},
-		},
-	}
-
-	// PrometheusFormatResponse formats the output based on the modified Config
-	output, err := util.PrometheusFormatResponse(prom_conf)
-	if err != nil {
-		t.Errorf("Error formatting output for Prometheus: %s", err)
-		t.Fail()
-	}
-
-	// Compare the Prometheus formatted output we expect vs what we actually got
-	compare(string(output), "test/prometheus_format.unit.txt", t)
-}
-
 //
 // Simple integration test, ensuring the 'happy path' works
 //
@@ -175,7 +127,7 @@ func TestIntegrationHappyPath(t *testing.T) {
 	}
 
 	// Fetch the default "all" pattern for the metrics
-	resp, err := http.Get(fmt.Sprintf("http://%s/probe?pattern=.*", address))
+	resp, err := http.Get(fmt.Sprintf("http://%s/probe?pattern=.*&target=localhost:2222", address))
 	if err != nil {
 		t.Errorf("Error fetching endpoint: %s\nIs the integration test host running?", err)
 		t.Fail()
@@ -0,0 +1,254 @@
+//
+// Copyright 2017 Nordstrom. All rights reserved.
+//
+
+//
+// ssh_exporter.sshcollector implements a prometheus.Collector that reports
+// the results of a single /probe scrape. Unlike the string-concatenated
+// exposition it replaces, it lets promhttp own metric naming, HELP/TYPE
+// lines, escaping, and content negotiation.
+//
+package sshcollector
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+//
+// Probe stores the outcome of running one module against one host, as
+// measured by a single /probe scrape.
+//
+type Probe struct {
+	Module             string
+	Host               string
+	Success            bool
+	Duration           float64 // seconds
+	ExitCode           int
+	PatternMatched     bool
+	SSHConnectDuration float64 // seconds
+	ScriptMetrics      []ScriptMetric
+	MetricParseErrors  float64
+}
+
+//
+// ScriptMetric is a single user-defined value extracted from a module's
+// script output. Its Name and Labels come from the module's `metrics:`
+// config, so, unlike the fixed ssh_probe_* metrics, the set of names and
+// label dimensions reported varies scrape to scrape.
+//
+type ScriptMetric struct {
+	Name   string
+	Type   string // "gauge" (default) or "counter"
+	Value  float64
+	Labels map[string]string
+}
+
+var (
+	successDesc = prometheus.NewDesc(
+		"ssh_probe_success",
+		"Whether the probe's script completed without error (1 for success, 0 for failure).",
+		[]string{"module", "host"}, nil,
+	)
+	durationDesc = prometheus.NewDesc(
+		"ssh_probe_duration_seconds",
+		"Total duration of the probe in seconds.",
+		[]string{"module", "host"}, nil,
+	)
+	exitCodeDesc = prometheus.NewDesc(
+		"ssh_probe_exit_code",
+		"Exit code of the probed script, or -1 if it could not be determined.",
+		[]string{"module", "host"}, nil,
+	)
+	patternMatchedDesc = prometheus.NewDesc(
+		"ssh_probe_pattern_matched",
+		"Whether the module's pattern matched the script's output (1 for match, 0 for no match).",
+		[]string{"module", "host"}, nil,
+	)
+	sshConnectDurationDesc = prometheus.NewDesc(
+		"ssh_probe_ssh_connect_duration_seconds",
+		"Duration of the SSH dial and session setup in seconds.",
+		[]string{"module", "host"}, nil,
+	)
+	metricParseErrorsDesc = prometheus.NewDesc(
+		"ssh_script_metric_parse_errors_total",
+		"Number of lines that matched a metrics regex but whose value could not be parsed as a float64.",
+		[]string{"module", "host"}, nil,
+	)
+)
+
+//
+// Collector implements prometheus.Collector for a fixed set of Probe
+// results. It is built fresh for every /probe scrape and registered against
+// a per-request prometheus.Registry, so the metrics it reports never
+// outlive the request that produced them.
+//
+// Describe intentionally does not advertise the ssh_script_<name> metrics
+// built from each Probe's ScriptMetrics: their names and label sets are
+// user-defined per module and vary scrape to scrape, so the Collector opts
+// out of go-client's startup consistency checking for them.
+//
+type Collector struct {
+	probes []Probe
+}
+
+//
+// New builds a Collector reporting the given probe results.
+//
+func New(probes []Probe) *Collector {
+
+	return &Collector{probes: probes}
+}
+
+//
+// Describe implements prometheus.Collector.
+//
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+
+	ch <- successDesc
+	ch <- durationDesc
+	ch <- exitCodeDesc
+	ch <- patternMatchedDesc
+	ch <- sshConnectDurationDesc
+	ch <- metricParseErrorsDesc
+}
+
+//
+// Collect implements prometheus.Collector.
+//
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+
+	for _, p := range c.probes {
+		ch <- prometheus.MustNewConstMetric(successDesc, prometheus.GaugeValue, boolToFloat(p.Success), p.Module, p.Host)
+		ch <- prometheus.MustNewConstMetric(durationDesc, prometheus.GaugeValue, p.Duration, p.Module, p.Host)
+		ch <- prometheus.MustNewConstMetric(exitCodeDesc, prometheus.GaugeValue, float64(p.ExitCode), p.Module, p.Host)
+		ch <- prometheus.MustNewConstMetric(patternMatchedDesc, prometheus.GaugeValue, boolToFloat(p.PatternMatched), p.Module, p.Host)
+		ch <- prometheus.MustNewConstMetric(sshConnectDurationDesc, prometheus.GaugeValue, p.SSHConnectDuration, p.Module, p.Host)
+		ch <- prometheus.MustNewConstMetric(metricParseErrorsDesc, prometheus.CounterValue, p.MetricParseErrors, p.Module, p.Host)
+
+		for _, sm := range dedupeScriptMetrics(p.ScriptMetrics) {
+			desc, valueType, labelValues := scriptMetricDesc(p, sm)
+			ch <- prometheus.MustNewConstMetric(desc, valueType, sm.Value, labelValues...)
+		}
+	}
+}
+
+//
+// dedupeScriptMetrics collapses ScriptMetrics that share a name and full
+// label set, which registry.Gather would otherwise reject as "collected
+// before with the same name and label values" and fail the whole scrape
+// over. This commonly happens when a metrics regex matches more than one
+// line of script output for the same label values: counters are summed, as
+// each match represents a further increment of the same series; gauges keep
+// the last match, as each represents a fresh reading of the same series.
+//
+func dedupeScriptMetrics(sms []ScriptMetric) []ScriptMetric {
+
+	order := make([]string, 0, len(sms))
+	byKey := make(map[string]ScriptMetric, len(sms))
+
+	for _, sm := range sms {
+		key := scriptMetricKey(sm)
+
+		existing, ok := byKey[key]
+		if !ok {
+			order = append(order, key)
+			byKey[key] = sm
+			continue
+		}
+
+		if sm.Type == "counter" {
+			existing.Value += sm.Value
+		} else {
+			existing.Value = sm.Value
+		}
+		byKey[key] = existing
+	}
+
+	deduped := make([]ScriptMetric, 0, len(order))
+	for _, key := range order {
+		deduped = append(deduped, byKey[key])
+	}
+
+	return deduped
+}
+
+//
+// scriptMetricKey identifies a ScriptMetric's output series: its name plus
+// its sorted label name=value pairs, leaving out module and host, which
+// scriptMetricDesc replaces with the probe's own values.
+//
+func scriptMetricKey(sm ScriptMetric) string {
+
+	names := make([]string, 0, len(sm.Labels))
+	for name := range sm.Labels {
+		if name == "module" || name == "host" {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	b.WriteString(sm.Name)
+	for _, name := range names {
+		b.WriteString("\x00")
+		b.WriteString(name)
+		b.WriteString("=")
+		b.WriteString(sm.Labels[name])
+	}
+
+	return b.String()
+}
+
+//
+// scriptMetricDesc builds the ad hoc Desc, ValueType, and label values for a
+// single user-defined ScriptMetric.
+//
+func scriptMetricDesc(p Probe, sm ScriptMetric) (*prometheus.Desc, prometheus.ValueType, []string) {
+
+	labelNames := []string{"module", "host"}
+	labelValues := []string{p.Module, p.Host}
+
+	names := make([]string, 0, len(sm.Labels))
+	for name := range sm.Labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		// module and host are always present; a labels_from group that
+		// reuses one of those names would otherwise produce a Desc with
+		// duplicate label names and panic in MustNewConstMetric below, so
+		// the user-defined value loses to the fixed one.
+		if name == "module" || name == "host" {
+			continue
+		}
+		labelNames = append(labelNames, name)
+		labelValues = append(labelValues, sm.Labels[name])
+	}
+
+	desc := prometheus.NewDesc(
+		fmt.Sprintf("ssh_script_%s", sm.Name),
+		fmt.Sprintf("User-defined metric %q extracted from script output.", sm.Name),
+		labelNames, nil,
+	)
+
+	valueType := prometheus.GaugeValue
+	if sm.Type == "counter" {
+		valueType = prometheus.CounterValue
+	}
+
+	return desc, valueType, labelValues
+}
+
+func boolToFloat(b bool) float64 {
+
+	if b {
+		return 1
+	}
+	return 0
+}
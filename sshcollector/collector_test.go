@@ -0,0 +1,172 @@
+package sshcollector
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+//
+// Tests that Collect emits one full set of metrics per probe result.
+//
+func TestUnitCollect(t *testing.T) {
+
+	fmt_probes := []Probe{
+		{
+			Module:             "scriptName",
+			Host:               "localhost",
+			Success:            true,
+			Duration:           1.5,
+			ExitCode:           0,
+			PatternMatched:     true,
+			SSHConnectDuration: 0.1,
+		},
+	}
+
+	c := New(fmt_probes)
+
+	registry := prometheus.NewRegistry()
+	if err := registry.Register(c); err != nil {
+		t.Fatalf("Error registering collector: %s", err)
+	}
+
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("Error gathering metrics: %s", err)
+	}
+
+	metricNames := map[string]bool{
+		"ssh_probe_success":                      false,
+		"ssh_probe_duration_seconds":              false,
+		"ssh_probe_exit_code":                     false,
+		"ssh_probe_pattern_matched":                false,
+		"ssh_probe_ssh_connect_duration_seconds":  false,
+		"ssh_script_metric_parse_errors_total":    false,
+	}
+
+	// ssh_script_metric_parse_errors_total is a counter; everything else
+	// above is a gauge.
+	counters := map[string]bool{
+		"ssh_script_metric_parse_errors_total": true,
+	}
+
+	for _, f := range families {
+		if _, ok := metricNames[f.GetName()]; ok {
+			metricNames[f.GetName()] = true
+		}
+		for _, m := range f.GetMetric() {
+			if counters[f.GetName()] {
+				if m.GetCounter() == (*dto.Counter)(nil) {
+					t.Errorf("Expected a counter metric for %s", f.GetName())
+				}
+				continue
+			}
+			if m.GetGauge() == (*dto.Gauge)(nil) {
+				t.Errorf("Expected a gauge metric for %s", f.GetName())
+			}
+		}
+	}
+
+	for name, seen := range metricNames {
+		if !seen {
+			t.Errorf("Expected metric %s to be reported", name)
+		}
+	}
+}
+
+//
+// Tests that user-defined ScriptMetrics are exposed as ssh_script_<name>
+// with their extracted value. Labels are not checked here; the "host" label
+// below is replaced by the probe's own host.
+//
+func TestUnitCollectScriptMetrics(t *testing.T) {
+
+	c := New([]Probe{
+		{
+			Module: "loadavg",
+			Host:   "localhost",
+			ScriptMetrics: []ScriptMetric{
+				{
+					Name:   "load1",
+					Type:   "gauge",
+					Value:  0.42,
+					Labels: map[string]string{"host": "db1"},
+				},
+			},
+		},
+	})
+
+	registry := prometheus.NewRegistry()
+	if err := registry.Register(c); err != nil {
+		t.Fatalf("Error registering collector: %s", err)
+	}
+
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("Error gathering metrics: %s", err)
+	}
+
+	found := false
+	for _, f := range families {
+		if f.GetName() != "ssh_script_load1" {
+			continue
+		}
+		found = true
+		if got := f.GetMetric()[0].GetGauge().GetValue(); got != 0.42 {
+			t.Errorf("Expected ssh_script_load1 value 0.42, got %v", got)
+		}
+	}
+
+	if !found {
+		t.Error("Expected ssh_script_load1 to be reported")
+	}
+}
+
+//
+// Tests that ScriptMetrics sharing a name and full label set are collapsed
+// into one sample instead of making the scrape fail with a duplicate-series
+// error: counters sum, gauges keep the last match.
+//
+func TestUnitCollectScriptMetricsDuplicateLabelSets(t *testing.T) {
+
+	c := New([]Probe{
+		{
+			Module: "loadavg",
+			Host:   "localhost",
+			ScriptMetrics: []ScriptMetric{
+				{Name: "load1", Type: "gauge", Value: 0.1, Labels: map[string]string{"cpu": "0"}},
+				{Name: "load1", Type: "gauge", Value: 0.2, Labels: map[string]string{"cpu": "0"}},
+				{Name: "errors", Type: "counter", Value: 1, Labels: map[string]string{"cpu": "0"}},
+				{Name: "errors", Type: "counter", Value: 1, Labels: map[string]string{"cpu": "0"}},
+			},
+		},
+	})
+
+	registry := prometheus.NewRegistry()
+	if err := registry.Register(c); err != nil {
+		t.Fatalf("Error registering collector: %s", err)
+	}
+
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("Error gathering metrics: %s", err)
+	}
+
+	for _, f := range families {
+		switch f.GetName() {
+		case "ssh_script_load1":
+			if got := len(f.GetMetric()); got != 1 {
+				t.Errorf("Expected 1 ssh_script_load1 sample, got %d", got)
+			} else if got := f.GetMetric()[0].GetGauge().GetValue(); got != 0.2 {
+				t.Errorf("Expected ssh_script_load1 value 0.2 (last match wins), got %v", got)
+			}
+		case "ssh_script_errors":
+			if got := len(f.GetMetric()); got != 1 {
+				t.Errorf("Expected 1 ssh_script_errors sample, got %d", got)
+			} else if got := f.GetMetric()[0].GetCounter().GetValue(); got != 2 {
+				t.Errorf("Expected ssh_script_errors value 2 (summed), got %v", got)
+			}
+		}
+	}
+}
@@ -0,0 +1,197 @@
+//
+// Copyright 2017 Nordstrom. All rights reserved.
+//
+
+//
+// ssh_exporter.util/auth.go builds *ssh.ClientConfig from a CredentialConfig,
+// supporting host-key verification (known_hosts or a pinned key), ssh-agent,
+// password, certificate, and encrypted-key authentication.
+//
+package util
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+//
+// sshConnectToHost connects to a given host using the auth and host-key
+// verification described by cred. insecureSkipHostKeyCheck, when true,
+// disables host-key verification entirely regardless of cred. The dial
+// itself is bound to ctx, so a canceled or expired ctx aborts a connection
+// attempt that is hanging rather than leaving it to run forever.
+//
+func sshConnectToHost(ctx context.Context, host, port string, cred CredentialConfig, insecureSkipHostKeyCheck bool) (*ssh.Client, *ssh.Session, error) {
+
+	auth, err := authMethods(cred)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	hostKeyCallback, err := hostKeyCallback(cred, insecureSkipHostKeyCheck)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sshConfig := &ssh.ClientConfig{
+		User:              cred.User,
+		Auth:              auth,
+		HostKeyCallback:   hostKeyCallback,
+		HostKeyAlgorithms: cred.HostKeyAlgorithms,
+	}
+	sshConfig.SetDefaults()
+
+	fullHost := fmt.Sprintf("%s:%s", host, port)
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", fullHost)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, fullHost, sshConfig)
+	if err != nil {
+		return nil, nil, err
+	}
+	client := ssh.NewClient(sshConn, chans, reqs)
+
+	session, err := client.NewSession()
+	if err != nil {
+		client.Close()
+		return nil, nil, err
+	}
+
+	return client, session, nil
+}
+
+//
+// authMethods builds the ssh.AuthMethod list for cred. KeyFile (optionally
+// paired with Certificate, and decrypted with Passphrase), Agent, and
+// Password are all tried when configured; at least one must be set.
+//
+func authMethods(cred CredentialConfig) ([]ssh.AuthMethod, error) {
+
+	var methods []ssh.AuthMethod
+
+	if cred.KeyFile != "" {
+		signer, err := signerFromKeyFile(cred.KeyFile, cred.Passphrase, cred.Certificate)
+		if err != nil {
+			return nil, err
+		}
+		methods = append(methods, ssh.PublicKeys(signer))
+	}
+
+	if cred.Agent {
+		signers, err := agentSigners()
+		if err != nil {
+			return nil, err
+		}
+		methods = append(methods, ssh.PublicKeysCallback(signers))
+	}
+
+	if cred.Password != "" {
+		methods = append(methods, ssh.Password(cred.Password))
+	}
+
+	if len(methods) == 0 {
+		return nil, fmt.Errorf("no auth method configured for host %q (keyfile, agent, or password required)", cred.Host)
+	}
+
+	return methods, nil
+}
+
+//
+// signerFromKeyFile loads the private key at keyfile, decrypting it with
+// passphrase if one is given, and pairs it with the OpenSSH certificate at
+// certFile when set.
+//
+func signerFromKeyFile(keyfile, passphrase, certFile string) (ssh.Signer, error) {
+
+	buf, err := ioutil.ReadFile(keyfile)
+	if err != nil {
+		return nil, err
+	}
+
+	var signer ssh.Signer
+	if passphrase != "" {
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(buf, []byte(passphrase))
+	} else {
+		signer, err = ssh.ParsePrivateKey(buf)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if certFile == "" {
+		return signer, nil
+	}
+
+	certBuf, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		return nil, err
+	}
+
+	pubKey, _, _, _, err := ssh.ParseAuthorizedKey(certBuf)
+	if err != nil {
+		return nil, err
+	}
+
+	cert, ok := pubKey.(*ssh.Certificate)
+	if !ok {
+		return nil, fmt.Errorf("%s does not contain an OpenSSH certificate", certFile)
+	}
+
+	return ssh.NewCertSigner(cert, signer)
+}
+
+//
+// agentSigners dials the ssh-agent listening on $SSH_AUTH_SOCK and returns
+// its signers.
+//
+func agentSigners() (func() ([]ssh.Signer, error), error) {
+
+	sock := os.Getenv("SSH_AUTH_SOCK")
+	if sock == "" {
+		return nil, fmt.Errorf("agent: true was set but SSH_AUTH_SOCK is not set")
+	}
+
+	conn, err := net.Dial("unix", sock)
+	if err != nil {
+		return nil, fmt.Errorf("agent: failed to dial SSH_AUTH_SOCK: %v", err)
+	}
+
+	return agent.NewClient(conn).Signers, nil
+}
+
+//
+// hostKeyCallback builds the ssh.HostKeyCallback described by cred.
+// insecureSkipHostKeyCheck, when true, always wins and disables verification
+// so that operators must consciously opt into the insecure behavior.
+//
+func hostKeyCallback(cred CredentialConfig, insecureSkipHostKeyCheck bool) (ssh.HostKeyCallback, error) {
+
+	if insecureSkipHostKeyCheck {
+		return ssh.InsecureIgnoreHostKey(), nil
+	}
+
+	if cred.KnownHosts != "" {
+		return knownhosts.New(cred.KnownHosts)
+	}
+
+	if cred.HostKey != "" {
+		pubKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(cred.HostKey))
+		if err != nil {
+			return nil, err
+		}
+		return ssh.FixedHostKey(pubKey), nil
+	}
+
+	return nil, fmt.Errorf("no host key verification configured for host %q; set known_hosts, host_key, or insecure_skip_host_key_check", cred.Host)
+}
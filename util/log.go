@@ -0,0 +1,63 @@
+//
+// Copyright 2017 Nordstrom. All rights reserved.
+//
+
+//
+// ssh_exporter.util/log.go configures the package's structured logger,
+// replacing the free-form strings LogMsg used to funnel through the
+// standard `log` package.
+//
+package util
+
+import (
+	"log/slog"
+	"os"
+	"strings"
+)
+
+//
+// Logger is the package-level logger used by FatalCheck, SoftCheck, and
+// anywhere else that doesn't have a more specific, request-scoped logger to
+// attach attributes to (see BatchExecute). main replaces it at startup with
+// the result of NewLogger once --log.level/--log.format are parsed.
+//
+var Logger = NewLogger("info", "logfmt")
+
+//
+// NewLogger builds a *slog.Logger for the given level
+// (debug|info|warn|error) and format (logfmt|json). logfmt keeps the
+// timestamp layout ("2006/01/02 15:04:05") used by the standard `log`
+// package this replaces, for operators relying on it for backwards
+// compatibility.
+//
+func NewLogger(level, format string) *slog.Logger {
+
+	opts := &slog.HandlerOptions{Level: parseLogLevel(level)}
+
+	if format == "json" {
+		return slog.New(slog.NewJSONHandler(os.Stderr, opts))
+	}
+
+	opts.ReplaceAttr = func(groups []string, a slog.Attr) slog.Attr {
+		if len(groups) == 0 && a.Key == slog.TimeKey {
+			a.Value = slog.StringValue(a.Value.Time().Format("2006/01/02 15:04:05"))
+		}
+		return a
+	}
+
+	return slog.New(slog.NewTextHandler(os.Stderr, opts))
+}
+
+func parseLogLevel(level string) slog.Level {
+
+	switch strings.ToLower(level) {
+	case "debug":
+		return slog.LevelDebug
+	case "warn":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
@@ -8,18 +8,20 @@
 package util
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"flag"
 	"fmt"
 	"io/ioutil"
-	"log"
+	"log/slog"
+	"net"
 	"net/http"
+	"os"
 	"regexp"
-	"strings"
 	"sync"
 	"time"
 
-	"golang.org/x/crypto/ssh"
 	"gopkg.in/yaml.v2"
 )
 
@@ -27,62 +29,153 @@ import (
 // Configuration file datastructure overview:
 //
 // version: v0
-// scripts:
+// modules:
 //   - name: 'name'
 //     script: 'script'
 //     timeout: 1s
-//     credentials:
-//     - host: 'host'
-//       user: 'user'
-//       keyfile: '/path/to/keyfile'
+// credentials:
+//   - host: 'host'
+//     user: 'user'
+//     keyfile: '/path/to/keyfile'
+//     known_hosts: '/path/to/known_hosts'
+//
+// See CredentialConfig for the full set of supported auth and host-key
+// verification options (passwords, ssh-agent, certificates, encrypted keys,
+// pinned host keys).
+//
+// Modules describe *what* to run; they no longer carry the hosts they run
+// against. Hosts are instead supplied per-scrape via the `/probe` endpoint's
+// `target` query parameter (mirroring blackbox_exporter), and credentials
+// are resolved from the separate Credentials store keyed by host (and,
+// optionally, scoped to a single module).
 //
 // Also includes internal data structures used to achieve a more sane
 // data-flow.
 //
 type Config struct {
-	Version string         `yaml:"version"`
-	Scripts []ScriptConfig `yaml:"scripts"`
+	Version     string             `yaml:"version"`
+	Modules     []ScriptConfig     `yaml:"modules"`
+	Credentials []CredentialConfig `yaml:"credentials"`
+
+	// InsecureSkipHostKeyCheck disables host key verification for every
+	// connection made by the exporter. It defaults to false; operators must
+	// opt into it explicitly.
+	InsecureSkipHostKeyCheck bool `yaml:"insecure_skip_host_key_check"`
 }
 
 //
-// ScriptConfig stores information about a given script including the name
-// (which can be used to filter results on the /probe endpoint), Script,
-// Timeout, Pattern (which determines if the script failed or not), and
-// Credentials (described below).
+// ScriptConfig stores information about a given module, including the Name
+// (which is matched against the /probe endpoint's "module" or "pattern"
+// query parameter), Script, Timeout, and Pattern (which determines if the
+// script failed or not).
 //
 // In addition to the above, ParsedTimeout is the go Duration of the user
-// provided Timeout value and Ignored stores weather or not the script is run
-// for a given request; it is ignored if Name does not match the query parameter
-// "pattern"
+// provided Timeout value and Ignored stores whether or not the module is run
+// for a given request.
+//
+// Metrics declares named gauges/counters to extract from the script's stdout
+// via regex capture groups, in addition to the boolean Pattern match.
+//
+// TargetHost, TargetPort, ScriptResult, ScriptReturnCode, ScriptError,
+// ResultPatternMatch, Duration, SSHConnectDuration, ScriptMetrics, and
+// MetricParseErrors are populated at runtime with the result of probing
+// TargetHost for this scrape.
 //
 type ScriptConfig struct {
-	Name          string             `yaml:"name"`
-	Script        string             `yaml:"script"`
-	Timeout       string             `yaml:"timeout"`
-	Pattern       string             `yaml:"pattern"`
-	Credentials   []CredentialConfig `yaml:"credentials"`
-	ParsedTimeout time.Duration      // For internal use only
-	Ignored       bool               // For internal use only
+	Name               string         `yaml:"name"`
+	Script             string         `yaml:"script"`
+	Timeout            string         `yaml:"timeout"`
+	Pattern            string         `yaml:"pattern"`
+	Metrics            []MetricConfig `yaml:"metrics"`
+	ParsedTimeout      time.Duration  // For internal use only
+	Ignored            bool           // For internal use only
+	TargetHost         string         // For internal use only
+	TargetPort         string         // For internal use only
+	ScriptResult       string         // For internal use only
+	ScriptReturnCode   int            // For internal use only
+	ScriptError        string         // For internal use only
+	ResultPatternMatch int8           // For internal use only
+	Duration           time.Duration  // For internal use only
+	SSHConnectDuration time.Duration  // For internal use only
+	ScriptMetrics      []MetricSample // For internal use only
+	MetricParseErrors  int            // For internal use only
 }
 
 //
-// CredentialConfig stores information about each host a script is to be run
-// on; at runtime the structure also stores the result of a given on that host.
+// MetricConfig declares a single named metric to extract from a script's
+// stdout. Regex must contain a named capture group identifying the value to
+// report (ValueFrom, defaulting to "value" when unset) parsed as a float64;
+// any other named groups listed in LabelsFrom become metric labels. By
+// default Regex is matched line-by-line; set Multiline to match it against
+// the script's entire stdout at once (e.g. to span lines with "(?s)").
+//
+type MetricConfig struct {
+	Name       string   `yaml:"name"`
+	Help       string   `yaml:"help"`
+	Type       string   `yaml:"type"` // "gauge" (default) or "counter"
+	Regex      string   `yaml:"regex"`
+	ValueFrom  string   `yaml:"value_from"`
+	LabelsFrom []string `yaml:"labels_from"`
+	Multiline  bool     `yaml:"multiline"`
+
+	parsedRegex *regexp.Regexp // For internal use only
+}
+
 //
-// User assigned values include host, port, user, and keyfile.
+// MetricSample is one value extracted from a script's stdout by a
+// MetricConfig's regex.
 //
-// Runtime determined ScriptResult, ScriptReturnCode, ScriptError, and
-// ResultPatternMatch
+type MetricSample struct {
+	Name   string
+	Type   string
+	Value  float64
+	Labels map[string]string
+}
+
+//
+// CredentialConfig stores the credentials used to authenticate to a given
+// host. Entries are looked up by Host; an optional Module scopes an entry to
+// a single module so that the same host can be probed with different
+// credentials depending on what's being run against it.
+//
+// Auth methods are tried in this order: KeyFile (decrypted with Passphrase
+// if set, and presented as Certificate instead of the bare key when
+// Certificate is set), Agent, Password. At least one must be set. HostKey or
+// KnownHosts verifies the server's identity; see Config.InsecureSkipHostKeyCheck
+// for the (explicit, opt-in) escape hatch.
 //
 type CredentialConfig struct {
-	Host               string `yaml:"host"`
-	Port               string `yaml:"port"`
-	User               string `yaml:"user"`
-	KeyFile            string `yaml:"keyfile"`
-	ScriptResult       string // For internal use only
-	ScriptReturnCode   int    // For internal use only
-	ScriptError        string // For internal use only
-	ResultPatternMatch int8   // For internal use only
+	Module  string `yaml:"module"`
+	Host    string `yaml:"host"`
+	Port    string `yaml:"port"`
+	User    string `yaml:"user"`
+	KeyFile string `yaml:"keyfile"`
+
+	// Passphrase decrypts KeyFile when it holds an encrypted private key.
+	Passphrase string `yaml:"passphrase"`
+
+	// Password authenticates with SSH password auth (keyboard-interactive is
+	// not attempted).
+	Password string `yaml:"password"`
+
+	// Agent authenticates using the agent listening on $SSH_AUTH_SOCK.
+	Agent bool `yaml:"agent"`
+
+	// Certificate points at an OpenSSH certificate to present alongside
+	// KeyFile.
+	Certificate string `yaml:"certificate"`
+
+	// KnownHosts points at an OpenSSH known_hosts file used to verify the
+	// server's host key.
+	KnownHosts string `yaml:"known_hosts"`
+
+	// HostKey pins a single authorized_keys-format public key as the only
+	// acceptable host key, as an alternative to KnownHosts.
+	HostKey string `yaml:"host_key"`
+
+	// HostKeyAlgorithms restricts which host key algorithms are accepted, in
+	// order of preference.
+	HostKeyAlgorithms []string `yaml:"host_key_algorithms"`
 }
 
 //
@@ -92,18 +185,19 @@ type CredentialConfig struct {
 func FatalCheck(e error) {
 
 	if e != nil {
-		log.Fatal("error: ", e)
+		Logger.Error(e.Error())
+		os.Exit(1)
 	}
 }
 
 //
-// SoftCheck logs non-nil errors to stderr. Used for runtime errors that should
-// not kill the server.
+// SoftCheck logs non-nil errors to Logger. Used for runtime errors that
+// should not kill the server.
 //
 func SoftCheck(e error) bool {
 
 	if e != nil {
-		LogMsg(fmt.Sprintf("%v", e))
+		Logger.Error(e.Error())
 		return true
 	} else {
 		return false
@@ -111,25 +205,21 @@ func SoftCheck(e error) bool {
 }
 
 //
-// LogMsg logs a string to stdout with timestamp.
-//
-func LogMsg(s string) {
-
-	log.Printf("ssh_exporter :: %s", fmt.Sprintf("%s", s))
-}
-
-//
-// ParseFlags parses the given commandline arguments and returns config and
-// port as a tuple.
+// ParseFlags parses the given commandline arguments and returns config,
+// port, web-config-file, concurrency, log level, and log format as a tuple.
 //
-func ParseFlags(c, p *string) (*string, *string) {
+func ParseFlags(c, p, w *string, concurrency *int, logLevel, logFormat *string) (*string, *string, *string, *int, *string, *string) {
 
 	flag.StringVar(c, "config", "config.yml", "Path to your ssh_exporter config file")
 	flag.StringVar(p, "port", "9428", "Port probed metrics are served on.")
+	flag.StringVar(w, "web.config.file", "", "Path to a web-config file enabling TLS and/or basic auth, per the Prometheus exporter-toolkit. When unset the server listens over plain HTTP.")
+	flag.IntVar(concurrency, "concurrency", DefaultConcurrency, "Maximum number of modules to run concurrently per scrape.")
+	flag.StringVar(logLevel, "log.level", "info", "Minimum severity to log: debug, info, warn, or error.")
+	flag.StringVar(logFormat, "log.format", "logfmt", "Log output format: logfmt or json.")
 
 	flag.Parse()
 
-	return c, p
+	return c, p, w, concurrency, logLevel, logFormat
 }
 
 func ParseConfig(c string) (Config, error) {
@@ -148,236 +238,261 @@ func ParseConfig(c string) (Config, error) {
 }
 
 //
-// ParseQuery parses HTTP query parameters for the 'pattern' query. Returns the
-// compiled regex pattern or an error.
-//
-func ParseQuery(w http.ResponseWriter, r *http.Request) (*regexp.Regexp, error) {
-
-	if r.URL.Query().Get("pattern") == "" {
-		return nil, errors.New("Probe endpoint was hit, but pattern parameter was not passed.")
-	}
-
-	p, err := regexp.Compile(string(r.URL.Query().Get("pattern")))
-	return p, err
-}
-
-//
-// BatchExecute runs the scripts described in the provided configuration file.
+// ParseQuery parses HTTP query parameters for the /probe endpoint.
 //
-// Conceptual overview (because this is a little complicated):
+// "target" is required and names the host (optionally "host:port") to probe.
+// The set of modules to run against it is selected by either "module" (an
+// exact module name) or "pattern" (a regex matched against module names),
+// mirroring the blackbox_exporter multi-target convention.
 //
-// A channel 't' is created as well as a sync.WaitGroup. These are used to
-// communicate between goroutines and the main thread.
-//
-// The main thread spawns each goroutine and then waits with done.Wait().  In
-// each goroutine the size of our sync.WaitGroup is incremented by 1. Once that
-// thread is done executing it's assigned script, it calls done(), unblocking
-// the WaitGroup.
-//
-// Once that stops blocking BatchExecute returns.
-//
-func BatchExecute(c *Config, p *regexp.Regexp) (Config, error) {
-
-	var done sync.WaitGroup
-	t := make(chan bool)
+func ParseQuery(w http.ResponseWriter, r *http.Request) (*regexp.Regexp, string, error) {
 
-	for i, v := range c.Scripts {
-		if p.MatchString(v.Name) != true {
-			c.Scripts[i].Ignored = true
-		} else {
-			go executeScript(v.Script, v.Pattern, &c.Scripts[i].Credentials, &done, t)
-		}
+	target := r.URL.Query().Get("target")
+	if target == "" {
+		return nil, "", errors.New("Probe endpoint was hit, but target parameter was not passed.")
 	}
 
-	done.Wait()
+	if module := r.URL.Query().Get("module"); module != "" {
+		p, err := regexp.Compile(fmt.Sprintf("^%s$", regexp.QuoteMeta(module)))
+		return p, target, err
+	}
 
-	for _, v := range c.Scripts {
-		if !v.Ignored {
-			for _, _ = range v.Credentials {
-				select {
-				case <-time.After(v.ParsedTimeout):
-				case <-t:
-				}
-			}
-		}
+	if pattern := r.URL.Query().Get("pattern"); pattern != "" {
+		p, err := regexp.Compile(pattern)
+		return p, target, err
 	}
 
-	return *c, nil
+	return nil, "", errors.New("Probe endpoint was hit, but neither module nor pattern parameter was passed.")
 }
 
 //
-// PrometheusFormatResponse converts the config struct to a
-// Prometheus-digestable format.
+// splitTarget splits a "host" or "host:port" target into its host and port.
+// When no port is present, defaultPort is returned.
 //
-func PrometheusFormatResponse(c Config) (string, error) {
-
-	var response string
-	exitStatusFormatStr := "ssh_exporter_%s_exit_status{name=\"%s\",host=\"%s\",user=\"%s\",script=\"%s\",exit_status=\"%d\"} %d"
-	patternMatchFormatStr := "ssh_exporter_%s_pattern_match{name=\"%s\",host=\"%s\",user=\"%s\",script=\"%s\",regex=\"%s\"} %d"
-
-	exitStatusHelpStr := "# HELP ssh_exporter_%s_exit_status Integer exit status of commands and metadata about the command's execution.\n# TYPE ssh_exporter gauge"
-	patternMatchHelpStr := "# HELP ssh_exporter_%s_pattern_match Boolean match of regex on output of script of commands and metadata about the command's execution.\n# TYPE ssh_exporter gauge"
+func splitTarget(target, defaultPort string) (string, string) {
 
-	for _, i := range c.Scripts {
-		if i.Ignored != true {
-			exitedDoc := fmt.Sprintf(exitStatusHelpStr, i.Name)
-			matchedDoc := fmt.Sprintf(patternMatchHelpStr, i.Name)
-
-			response = fmt.Sprintf("%s%s", response, exitedDoc)
-			for _, j := range i.Credentials {
-				s := fmt.Sprintf(exitStatusFormatStr, i.Name, i.Name, j.Host, j.User, i.Script, j.ScriptReturnCode, j.ScriptReturnCode)
-				response = fmt.Sprintf("%s\n%s", response, s)
-			}
-			response = fmt.Sprintf("%s\n%s", response, matchedDoc)
-			for _, j := range i.Credentials {
-				m := fmt.Sprintf(patternMatchFormatStr, i.Name, i.Name, j.Host, j.User, i.Script, i.Pattern, j.ResultPatternMatch)
-				response = fmt.Sprintf("%s\n%s", response, m)
-			}
-			response = fmt.Sprintf("%s\n", response)
-		}
+	host, port, err := net.SplitHostPort(target)
+	if err != nil {
+		return target, defaultPort
 	}
 
-	return response, nil
+	return host, port
 }
 
 //
-// AdjustConfig makes small changes to ensure the config file provided is
-// consistent.
+// FindCredential resolves the credentials to use for a given module/host
+// pair. A CredentialConfig scoped to the module (via its Module field) takes
+// precedence over one that applies to the host generally.
 //
-func adjustConfig(c Config) (Config, error) {
+func FindCredential(c *Config, module, host string) (CredentialConfig, error) {
 
-	for c_i, v_i := range c.Scripts {
-		for c_j, v_j := range v_i.Credentials {
-			if v_j.Port == "" {
-				c.Scripts[c_i].Credentials[c_j].Port = "22"
-			}
+	var fallback *CredentialConfig
+
+	for i, cred := range c.Credentials {
+		if cred.Host != host {
+			continue
 		}
 
-		tmp, err := time.ParseDuration(c.Scripts[c_i].Timeout)
-		if !SoftCheck(err) {
-			c.Scripts[c_i].ParsedTimeout = tmp
-		} else {
-			LogMsg(fmt.Sprintf("Failed to parse `timeout` for %s. Default to 10s", c.Scripts[c_i].Name))
-			c.Scripts[c_i].ParsedTimeout, _ = time.ParseDuration("10s")
+		if cred.Module == module {
+			return c.Credentials[i], nil
+		}
+
+		if cred.Module == "" {
+			fallback = &c.Credentials[i]
 		}
 	}
 
-	return c, nil
+	if fallback != nil {
+		return *fallback, nil
+	}
+
+	return CredentialConfig{}, fmt.Errorf("no credentials configured for host %q", host)
 }
 
 //
-// executeScript runs a given script on each assigned host, spawning a
-// goroutine for each host in the CredentialConfig provided.
+// DefaultConcurrency bounds how many modules BatchExecute runs at once when
+// no concurrency limit is given.
+//
+const DefaultConcurrency = 10
+
+//
+// BatchExecute runs every module matching p against target, resolving
+// per-module credentials from c.Credentials and recording the result back
+// onto the matching entry in c.Modules. At most concurrency modules run at
+// once; concurrency <= 0 is treated as DefaultConcurrency.
 //
-// TLDR executeScript runs the  given script in parallel on all hosts.
+// Each goroutine below receives the per-module values (i, cred, host, port)
+// as its own parameters, never closing over the loop variables themselves,
+// so it never races with a later iteration reusing them.
 //
-func executeScript(script, pattern string, creds *[]CredentialConfig, done *sync.WaitGroup, t chan bool) {
+// logger receives a "script" and "host" attribute for every module run, so
+// a probe failure can be correlated back to the request that caused it. A
+// nil logger is treated as Logger.
+//
+func BatchExecute(c *Config, p *regexp.Regexp, target string, concurrency int, logger *slog.Logger) (Config, error) {
 
-	match, _ := regexp.Compile(pattern)
+	if concurrency <= 0 {
+		concurrency = DefaultConcurrency
+	}
+	if logger == nil {
+		logger = Logger
+	}
 
-	for i, c := range *creds {
-		done.Add(1)
-		go func() {
-			result, status, err := executeScriptOnHost(c.Host, c.Port, c.User, c.KeyFile, script)
+	var done sync.WaitGroup
+	sem := make(chan struct{}, concurrency)
 
-			(*creds)[i].ScriptReturnCode = status
-			(*creds)[i].ScriptResult = result
+	for i, v := range c.Modules {
+		if p.MatchString(v.Name) != true {
+			c.Modules[i].Ignored = true
+			continue
+		}
 
-			if err != nil {
-				(*creds)[i].ScriptError = fmt.Sprintf("%v", err)
-			}
+		host, port := splitTarget(target, "22")
+		scriptLogger := logger.With(slog.String("script", v.Name), slog.String("host", host))
 
-			if match.MatchString(result) {
-				(*creds)[i].ResultPatternMatch = 1
-			} else {
-				(*creds)[i].ResultPatternMatch = 0
-			}
+		cred, err := FindCredential(c, v.Name, host)
+		if err != nil {
+			scriptLogger.Error("credential lookup failed", slog.Any("err", err))
+			c.Modules[i].ScriptError = fmt.Sprintf("%v", err)
+			c.Modules[i].ScriptReturnCode = -1
+			continue
+		}
+		if cred.Port != "" {
+			port = cred.Port
+		}
+
+		done.Add(1)
+		go func(i int, cred CredentialConfig, host, port string) {
+			defer done.Done()
 
-			t <- true
+			sem <- struct{}{}
+			defer func() { <-sem }()
 
-			done.Done()
-		}()
+			executeScript(&c.Modules[i], cred, host, port, c.InsecureSkipHostKeyCheck, scriptLogger)
+		}(i, cred, host, port)
 	}
+
+	done.Wait()
+
+	return *c, nil
 }
 
 //
-// executeScriptOnHost executes a given script on a given host.
+// AdjustConfig makes small changes to ensure the config file provided is
+// consistent.
 //
-func executeScriptOnHost(host, port, user, keyfile, script string) (string, int, error) {
-
-	client, session, err := sshConnectToHost(host, port, user, keyfile)
-	if SoftCheck(err) {
-		return "", -1, err
-	}
+func adjustConfig(c Config) (Config, error) {
 
-	out, err := session.CombinedOutput(script)
-	if SoftCheck(err) {
-		var errorStatusCode int
-		fmt.Sscanf(fmt.Sprintf("%v", err), "Process exited with status %d", &errorStatusCode)
-		if errorStatusCode != 0 {
-			return "", errorStatusCode, err
+	for c_i, v_i := range c.Modules {
+		tmp, err := time.ParseDuration(v_i.Timeout)
+		if !SoftCheck(err) {
+			c.Modules[c_i].ParsedTimeout = tmp
 		} else {
-			return "", -1, err
+			Logger.Warn("failed to parse `timeout`, defaulting to 10s", slog.String("script", v_i.Name))
+			c.Modules[c_i].ParsedTimeout, _ = time.ParseDuration("10s")
 		}
-	}
-	defer client.Close()
-	defer session.Close()
 
-	return literalFormat(string(out)), 0, nil
+		for m_i, m_v := range v_i.Metrics {
+			re, err := regexp.Compile(m_v.Regex)
+			if SoftCheck(err) {
+				Logger.Warn("failed to parse `regex` for metric, it will be skipped", slog.String("metric", m_v.Name), slog.String("script", v_i.Name))
+				continue
+			}
+			c.Modules[c_i].Metrics[m_i].parsedRegex = re
+		}
+	}
 
+	return c, nil
 }
 
 //
-// sshConnectToHost connects to a given host with the given keyfile.
+// executeScript runs a single module against a single host, writing its
+// result back onto m. The script is killed if it has not finished within
+// m.ParsedTimeout. logger is expected to already carry "script" and "host"
+// attributes (see BatchExecute).
 //
-func sshConnectToHost(host, port, user, keyfile string) (*ssh.Client, *ssh.Session, error) {
+func executeScript(m *ScriptConfig, cred CredentialConfig, host, port string, insecureSkipHostKeyCheck bool, logger *slog.Logger) {
 
-	key, err := getKeyFile(keyfile)
-	SoftCheck(err)
+	match, _ := regexp.Compile(m.Pattern)
 
-	sshConfig := &ssh.ClientConfig{
-		User: user,
-		Auth: []ssh.AuthMethod{
-			ssh.PublicKeys(key),
-		},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-	}
-	sshConfig.SetDefaults()
+	ctx, cancel := context.WithTimeout(context.Background(), m.ParsedTimeout)
+	defer cancel()
+
+	start := time.Now()
+	result, status, connectDuration, err := executeScriptOnHost(ctx, host, port, cred, insecureSkipHostKeyCheck, m.Script, logger)
+
+	m.TargetHost = host
+	m.TargetPort = port
+	m.ScriptReturnCode = status
+	m.ScriptResult = result
+	m.SSHConnectDuration = connectDuration
+	m.Duration = time.Since(start)
 
-	fullHost := fmt.Sprintf("%s:%s", host, port)
-	client, err := ssh.Dial("tcp", fullHost, sshConfig)
 	if err != nil {
-		return nil, nil, err
+		m.ScriptError = fmt.Sprintf("%v", err)
+		logger.Error("script failed", slog.Any("err", err), slog.Duration("elapsed", m.Duration))
+	} else {
+		logger.Debug("script completed", slog.Duration("elapsed", m.Duration))
 	}
 
-	session, err := client.NewSession()
-	if err != nil {
-		return nil, nil, err
+	if match.MatchString(result) {
+		m.ResultPatternMatch = 1
+	} else {
+		m.ResultPatternMatch = 0
 	}
 
-	return client, session, nil
+	extractMetrics(m)
 }
 
 //
-// getKeyFile provides an ssh.Signer for the given keyfile (path to a private key).
+// executeScriptOnHost executes a given script on a given host, returning the
+// time spent dialing and setting up the SSH session separately from the time
+// spent running the script itself. If ctx is canceled or its deadline
+// expires before the script finishes, the session is closed, which
+// terminates the remote command rather than leaving it running.
 //
-func getKeyFile(keyfile string) (ssh.Signer, error) {
+func executeScriptOnHost(ctx context.Context, host, port string, cred CredentialConfig, insecureSkipHostKeyCheck bool, script string, logger *slog.Logger) (string, int, time.Duration, error) {
 
-	buf, err := ioutil.ReadFile(keyfile)
-	SoftCheck(err)
+	connectStart := time.Now()
+	client, session, err := sshConnectToHost(ctx, host, port, cred, insecureSkipHostKeyCheck)
+	connectDuration := time.Since(connectStart)
+	if err != nil {
+		logger.Error("ssh connect failed", slog.Any("err", err), slog.Duration("elapsed", connectDuration))
+		return "", -1, connectDuration, err
+	}
+	defer client.Close()
+	defer session.Close()
 
-	key, err := ssh.ParsePrivateKey(buf)
-	SoftCheck(err)
+	var out bytes.Buffer
+	session.Stdout = &out
+	session.Stderr = &out
 
-	return key, nil
-}
+	if err := session.Start(script); err != nil {
+		return "", -1, connectDuration, err
+	}
 
-//
-// literalFormat formats a string to be included in an endpoint to be scraped by Prometheus.
-//
-// Turns newline characters into '\n' characters.
-//
-func literalFormat(input string) string {
+	waitErr := make(chan error, 1)
+	go func() { waitErr <- session.Wait() }()
+
+	select {
+	case <-ctx.Done():
+		session.Close()
+		return "", -1, connectDuration, fmt.Errorf("script on %s timed out: %v", host, ctx.Err())
+
+	case err := <-waitErr:
+		if err != nil {
+			// The script still produced real stdout/stderr even though it
+			// exited non-zero, and health-check scripts commonly do; keep
+			// it so the pattern match and metrics regexes still have
+			// something to run against.
+			var errorStatusCode int
+			fmt.Sscanf(fmt.Sprintf("%v", err), "Process exited with status %d", &errorStatusCode)
+			if errorStatusCode != 0 {
+				return out.String(), errorStatusCode, connectDuration, err
+			}
+			return out.String(), -1, connectDuration, err
+		}
 
-	return strings.Replace(input, "\n", "\\n", -1)
+		return out.String(), 0, connectDuration, nil
+	}
 }
@@ -0,0 +1,72 @@
+package util
+
+import (
+	"regexp"
+	"testing"
+	"time"
+)
+
+//
+// Tests that BatchExecute writes each module's result to its own Modules
+// slot without racing, even when several modules run concurrently. Run with
+// `go test -race` to verify.
+//
+// No host key verification is configured for the credential below, so every
+// module fails fast in hostKeyCallback before any network I/O happens,
+// keeping this test hermetic.
+//
+func TestRaceBatchExecute(t *testing.T) {
+
+	conf := Config{
+		Modules: []ScriptConfig{
+			{Name: "a", Script: "true", Timeout: "100ms", Pattern: ".*"},
+			{Name: "b", Script: "true", Timeout: "100ms", Pattern: ".*"},
+			{Name: "c", Script: "true", Timeout: "100ms", Pattern: ".*"},
+		},
+		Credentials: []CredentialConfig{
+			{Host: "127.0.0.1", User: "nobody", Password: "x"},
+		},
+	}
+
+	conf, err := adjustConfig(conf)
+	if err != nil {
+		t.Fatalf("Error adjusting config: %s", err)
+	}
+
+	result, err := BatchExecute(&conf, regexp.MustCompile(".*"), "127.0.0.1", 2, nil)
+	if err != nil {
+		t.Fatalf("Error from BatchExecute: %s", err)
+	}
+
+	for _, m := range result.Modules {
+		if m.TargetHost != "127.0.0.1" {
+			t.Errorf("Expected module %s to record TargetHost, got %q", m.Name, m.TargetHost)
+		}
+		if m.ScriptError == "" {
+			t.Errorf("Expected module %s to fail fast without host key verification configured", m.Name)
+		}
+	}
+}
+
+//
+// Tests that executeScript reports an error, rather than hanging, when the
+// SSH connection cannot be set up. The timeout path itself is not reached.
+//
+func TestUnitExecuteScriptConnectError(t *testing.T) {
+
+	m := &ScriptConfig{
+		Name:          "slow",
+		Script:        "sleep 5",
+		Pattern:       ".*",
+		ParsedTimeout: 10 * time.Millisecond,
+	}
+
+	// No host key verification configured, so this fails in hostKeyCallback
+	// well before the timeout could matter; it documents the contract that
+	// executeScript always returns promptly.
+	executeScript(m, CredentialConfig{Host: "127.0.0.1", User: "nobody", Password: "x"}, "127.0.0.1", "22", false, Logger)
+
+	if m.ScriptError == "" {
+		t.Error("Expected ScriptError to be set")
+	}
+}
@@ -0,0 +1,107 @@
+//
+// Copyright 2017 Nordstrom. All rights reserved.
+//
+
+//
+// ssh_exporter.util/metrics.go extracts user-defined MetricSamples from a
+// module's script output, turning the exporter from a boolean
+// pattern-checker into a general-purpose SSH-scraped metric source.
+//
+package util
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+//
+// extractMetrics runs every compiled MetricConfig regex in m.Metrics against
+// m.ScriptResult, populating m.ScriptMetrics with the parsed samples and
+// m.MetricParseErrors with the count of matches whose value group could not
+// be parsed as a float64.
+//
+func extractMetrics(m *ScriptConfig) {
+
+	m.ScriptMetrics = nil
+	m.MetricParseErrors = 0
+
+	for _, metric := range m.Metrics {
+		if metric.parsedRegex == nil {
+			continue
+		}
+
+		valueGroup := metric.ValueFrom
+		if valueGroup == "" {
+			valueGroup = "value"
+		}
+
+		for _, match := range findMatches(metric, m.ScriptResult) {
+			groups := namedGroups(metric.parsedRegex, match)
+
+			raw, ok := groups[valueGroup]
+			if !ok {
+				continue
+			}
+
+			value, err := strconv.ParseFloat(raw, 64)
+			if err != nil {
+				m.MetricParseErrors++
+				continue
+			}
+
+			labels := make(map[string]string, len(metric.LabelsFrom))
+			for _, l := range metric.LabelsFrom {
+				labels[l] = groups[l]
+			}
+
+			m.ScriptMetrics = append(m.ScriptMetrics, MetricSample{
+				Name:   metric.Name,
+				Type:   metric.Type,
+				Value:  value,
+				Labels: labels,
+			})
+		}
+	}
+}
+
+//
+// findMatches returns every regex match for metric against output. In the
+// default (line-by-line) mode the regex is run against each line
+// independently; in Multiline mode it is run against the entire output at
+// once, so the regex itself is responsible for spanning lines (e.g. via the
+// "(?s)" flag).
+//
+func findMatches(metric MetricConfig, output string) [][]string {
+
+	if metric.Multiline {
+		return metric.parsedRegex.FindAllStringSubmatch(output, -1)
+	}
+
+	var matches [][]string
+	for _, line := range strings.Split(output, "\n") {
+		if match := metric.parsedRegex.FindStringSubmatch(line); match != nil {
+			matches = append(matches, match)
+		}
+	}
+
+	return matches
+}
+
+//
+// namedGroups maps a regex's named capture groups to the substrings they
+// matched.
+//
+func namedGroups(re *regexp.Regexp, match []string) map[string]string {
+
+	groups := make(map[string]string)
+
+	for i, name := range re.SubexpNames() {
+		if i == 0 || name == "" || i >= len(match) {
+			continue
+		}
+		groups[name] = match[i]
+	}
+
+	return groups
+}
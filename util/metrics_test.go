@@ -0,0 +1,42 @@
+package util
+
+import (
+	"regexp"
+	"testing"
+)
+
+//
+// Tests that extractMetrics parses one sample per matching line and counts
+// parse failures separately.
+//
+func TestUnitExtractMetrics(t *testing.T) {
+
+	m := &ScriptConfig{
+		ScriptResult: "load1 0.42 host=db1\nload1 bogus host=db2\n",
+		Metrics: []MetricConfig{
+			{
+				Name:       "load1",
+				Type:       "gauge",
+				LabelsFrom: []string{"host"},
+				parsedRegex: regexp.MustCompile(
+					`^load1 (?P<value>\S+) host=(?P<host>\S+)$`,
+				),
+			},
+		},
+	}
+
+	extractMetrics(m)
+
+	if len(m.ScriptMetrics) != 1 {
+		t.Fatalf("Expected 1 parsed sample, got %d", len(m.ScriptMetrics))
+	}
+
+	sample := m.ScriptMetrics[0]
+	if sample.Value != 0.42 || sample.Labels["host"] != "db1" {
+		t.Errorf("Unexpected sample: %+v", sample)
+	}
+
+	if m.MetricParseErrors != 1 {
+		t.Errorf("Expected 1 metric parse error, got %d", m.MetricParseErrors)
+	}
+}